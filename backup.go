@@ -0,0 +1,440 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupSchemaVersion is the version of the JSON backup format itself (not
+// the Firefox places.sqlite schema, which is separately recorded per-backup
+// as PlacesSchemaVersion so undo can refuse to run across an incompatible
+// Firefox upgrade).
+const backupSchemaVersion = 1
+
+// backupFile is the on-disk format written by exportBackup and read back by
+// performUndo. Rows are kept as generic column-name-to-value maps (rather
+// than a fixed struct) so a backup taken against one places.sqlite schema
+// version can still be inspected, even if it can no longer be safely
+// replayed, after a Firefox upgrade changes the table shape.
+type backupFile struct {
+	BackupSchemaVersion int                      `json:"backup_schema_version"`
+	PlacesSchemaVersion int                      `json:"places_schema_version"`
+	CreatedAt           string                   `json:"created_at"`
+	ProfilePath         string                   `json:"profile_path"`
+	Origins             []map[string]interface{} `json:"origins"`
+	Places              []map[string]interface{} `json:"places"`
+	Visits              []map[string]interface{} `json:"visits"`
+	Annos               []map[string]interface{} `json:"annos"`
+	PlacesMetadata      []map[string]interface{} `json:"places_metadata"`
+	InputHistory        []map[string]interface{} `json:"input_history"`
+}
+
+// exportBackup serializes the moz_places, moz_historyvisits,
+// moz_inputhistory, moz_annos, moz_places_metadata, and moz_origins rows
+// about to be deleted to a timestamped JSON file, so a mistyped word in
+// words.txt doesn't permanently destroy history. It mirrors exactly what
+// cleanupHistory's delete transaction touches, so undo can put the profile
+// back the way it found it rather than leaving dangling origin_id
+// references. It returns the path written, or "" if there was nothing to
+// back up.
+func exportBackup(db *sql.DB, profilePath string, placeIDs []int64, autocompleteToDelete []string) (string, error) {
+	if len(placeIDs) == 0 && len(autocompleteToDelete) == 0 {
+		return "", nil
+	}
+
+	var placesSchemaVersion int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&placesSchemaVersion); err != nil {
+		return "", fmt.Errorf("could not read places.sqlite schema version: %w", err)
+	}
+
+	backup := backupFile{
+		BackupSchemaVersion: backupSchemaVersion,
+		PlacesSchemaVersion: placesSchemaVersion,
+		CreatedAt:           time.Now().Format(time.RFC3339),
+		ProfilePath:         profilePath,
+	}
+
+	if len(placeIDs) > 0 {
+		idPlaceholders := "?" + strings.Repeat(",?", len(placeIDs)-1)
+		idArgs := make([]interface{}, len(placeIDs))
+		for i, id := range placeIDs {
+			idArgs[i] = id
+		}
+
+		// Back up the origins the doomed places point at before the delete
+		// transaction's "WHERE id NOT IN (SELECT DISTINCT origin_id FROM
+		// moz_places)" pass can drop them as orphans.
+		origins, err := rowsToMaps(db, "SELECT * FROM moz_origins WHERE id IN (SELECT DISTINCT origin_id FROM moz_places WHERE id IN ("+idPlaceholders+"))", idArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_origins rows to back up: %w", err)
+		}
+		backup.Origins = origins
+
+		places, err := rowsToMaps(db, "SELECT * FROM moz_places WHERE id IN ("+idPlaceholders+")", idArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_places rows to back up: %w", err)
+		}
+		backup.Places = places
+
+		visits, err := rowsToMaps(db, "SELECT * FROM moz_historyvisits WHERE place_id IN ("+idPlaceholders+")", idArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_historyvisits rows to back up: %w", err)
+		}
+		backup.Visits = visits
+
+		annos, err := rowsToMaps(db, "SELECT * FROM moz_annos WHERE place_id IN ("+idPlaceholders+")", idArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_annos rows to back up: %w", err)
+		}
+		backup.Annos = annos
+
+		placesMetadata, err := rowsToMaps(db, "SELECT * FROM moz_places_metadata WHERE place_id IN ("+idPlaceholders+")", idArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_places_metadata rows to back up: %w", err)
+		}
+		backup.PlacesMetadata = placesMetadata
+	}
+
+	if len(autocompleteToDelete) > 0 {
+		inputPlaceholders := "?" + strings.Repeat(",?", len(autocompleteToDelete)-1)
+		inputArgs := make([]interface{}, len(autocompleteToDelete))
+		for i, v := range autocompleteToDelete {
+			inputArgs[i] = v
+		}
+		inputHistory, err := rowsToMaps(db, "SELECT * FROM moz_inputhistory WHERE input IN ("+inputPlaceholders+")", inputArgs...)
+		if err != nil {
+			return "", fmt.Errorf("error reading moz_inputhistory rows to back up: %w", err)
+		}
+		backup.InputHistory = inputHistory
+	}
+
+	path := filepath.Join(profilePath, fmt.Sprintf("cleanup-%s.json", time.Now().Format("20060102-150405")))
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding backup: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("error writing backup file %s: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// performUndo reads a backup written by exportBackup and re-inserts its rows
+// into the given profile's places.sqlite. It refuses to run if the profile's
+// current schema version doesn't match the one recorded at backup time,
+// since a Firefox upgrade in between may have changed column shapes in ways
+// a blind INSERT can't handle safely.
+func performUndo(profilePath, undoFile string) error {
+	data, err := os.ReadFile(undoFile)
+	if err != nil {
+		return fmt.Errorf("could not read backup file: %w", err)
+	}
+
+	var backup backupFile
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return fmt.Errorf("could not parse backup file: %w", err)
+	}
+
+	dbPath := filepath.Join(profilePath, "places.sqlite")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", dbPath))
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer db.Close()
+
+	var currentSchemaVersion int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&currentSchemaVersion); err != nil {
+		return fmt.Errorf("could not read places.sqlite schema version: %w", err)
+	}
+	if currentSchemaVersion != backup.PlacesSchemaVersion {
+		return fmt.Errorf("backup was taken against places.sqlite schema version %d, but this profile is now at version %d; refusing to undo across a schema migration", backup.PlacesSchemaVersion, currentSchemaVersion)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	// Origins are restored first since moz_places.origin_id references them;
+	// places before visits/annos/metadata, which reference place_id.
+	restoredOrigins, skippedOrigins, err := restoreRows(tx, "moz_origins", backup.Origins, retryWithFreshID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_origins rows: %w", err)
+	}
+	restoredPlaces, skippedPlaces, placeIDRemap, err := restorePlaces(tx, backup.Places)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_places rows: %w", err)
+	}
+	// A row whose place_id pointed at a place that had to be re-inserted
+	// under a new id (see restorePlaces) must follow it to the new id, or
+	// it would silently attach to whatever unrelated place now holds the
+	// old one.
+	remapPlaceID(backup.Visits, placeIDRemap)
+	remapPlaceID(backup.Annos, placeIDRemap)
+	remapPlaceID(backup.PlacesMetadata, placeIDRemap)
+
+	restoredVisits, skippedVisits, err := restoreRows(tx, "moz_historyvisits", backup.Visits, retryWithFreshID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_historyvisits rows: %w", err)
+	}
+	restoredAnnos, skippedAnnos, err := restoreRows(tx, "moz_annos", backup.Annos, retryWithFreshID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_annos rows: %w", err)
+	}
+	restoredMetadata, skippedMetadata, err := restoreRows(tx, "moz_places_metadata", backup.PlacesMetadata, retryWithFreshID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_places_metadata rows: %w", err)
+	}
+	// moz_inputhistory's primary key is (place_id, input), not an id column,
+	// so a conflict here means the same autocomplete entry already exists,
+	// not an id collision — no retry applies.
+	restoredInputs, skippedInputs, err := restoreRows(tx, "moz_inputhistory", backup.InputHistory, nil)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not restore moz_inputhistory rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit restore transaction: %w", err)
+	}
+
+	fmt.Printf("- Restored %d origin(s), %d place(s), %d visit(s), %d annotation(s), %d page metadata row(s), and %d autocomplete entry(ies) from %s.\n",
+		restoredOrigins, restoredPlaces, restoredVisits, restoredAnnos, restoredMetadata, restoredInputs, undoFile)
+	skipped := skippedOrigins + skippedPlaces + skippedVisits + skippedAnnos + skippedMetadata + skippedInputs
+	if skipped > 0 {
+		fmt.Printf("⚠️  %d row(s) (%d origin(s), %d place(s), %d visit(s), %d annotation(s), %d page metadata row(s), %d autocomplete entry(ies)) already existed in the profile and were left untouched.\n",
+			skipped, skippedOrigins, skippedPlaces, skippedVisits, skippedAnnos, skippedMetadata, skippedInputs)
+	}
+	return nil
+}
+
+// restoreRows re-inserts each backed-up row into table, returning how many
+// were actually inserted versus skipped because a row with the same primary
+// key or unique constraint already existed.
+func restoreRows(tx *sql.Tx, table string, rows []map[string]interface{}, onConflict func(table string, row map[string]interface{}) (map[string]interface{}, error)) (restored, skipped int, err error) {
+	for _, row := range rows {
+		inserted, _, err := restoreRow(tx, table, row)
+		if err != nil {
+			return restored, skipped, err
+		}
+		if !inserted && onConflict != nil {
+			retryRow, err := onConflict(table, row)
+			if err != nil {
+				return restored, skipped, err
+			}
+			if retryRow != nil {
+				inserted, _, err = restoreRow(tx, table, retryRow)
+				if err != nil {
+					return restored, skipped, err
+				}
+			}
+		}
+		if inserted {
+			restored++
+		} else {
+			skipped++
+		}
+	}
+	return restored, skipped, nil
+}
+
+// restorePlaces re-inserts each backed-up moz_places row. moz_places has no
+// AUTOINCREMENT on its id, so SQLite is free to hand a deleted row's id to
+// unrelated new history recorded since the backup was taken; when that
+// happens the INSERT OR IGNORE is silently dropped whether the collision
+// landed on the id, the unique guid, or both. So every ignored insert is
+// retried once under both a freshly generated guid and a dropped id (letting
+// SQLite assign a new one), rather than only retrying when the guid was
+// provably the culprit — a row is only truly un-restorable if that retry
+// fails too (e.g. its url collides with a place that already exists).
+// idRemap maps every original id that had to change to its new one, so
+// restorePlaces's caller can carry moz_historyvisits/moz_annos/
+// moz_places_metadata rows' place_id along with it.
+func restorePlaces(tx *sql.Tx, rows []map[string]interface{}) (restored, skipped int, idRemap map[int64]int64, err error) {
+	idRemap = map[int64]int64{}
+	for _, row := range rows {
+		inserted, _, err := restoreRow(tx, "moz_places", row)
+		if err != nil {
+			return restored, skipped, idRemap, err
+		}
+		if !inserted {
+			retry := make(map[string]interface{}, len(row))
+			for k, v := range row {
+				retry[k] = v
+			}
+			newGUID, err := newPlacesGUID()
+			if err != nil {
+				return restored, skipped, idRemap, err
+			}
+			retry["guid"] = newGUID
+			delete(retry, "id")
+
+			var newID int64
+			inserted, newID, err = restoreRow(tx, "moz_places", retry)
+			if err != nil {
+				return restored, skipped, idRemap, err
+			}
+			if inserted {
+				if origID, ok := toInt64(row["id"]); ok && origID != newID {
+					idRemap[origID] = newID
+				}
+			}
+		}
+		if inserted {
+			restored++
+		} else {
+			skipped++
+		}
+	}
+	return restored, skipped, idRemap, nil
+}
+
+// retryWithFreshID returns a copy of row with its id column dropped, so a
+// retried INSERT lets SQLite assign a new one instead of colliding again —
+// for tables whose rows, like moz_places (see restorePlaces), have no
+// AUTOINCREMENT and so can have their id reused by unrelated rows recorded
+// since the backup was taken. It returns nil for rows with no id column
+// (moz_inputhistory, whose primary key is place_id+input), since a conflict
+// there means the same entry already exists rather than an id collision.
+func retryWithFreshID(_ string, row map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := row["id"]; !ok {
+		return nil, nil
+	}
+	retry := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		retry[k] = v
+	}
+	delete(retry, "id")
+	return retry, nil
+}
+
+// remapPlaceID rewrites each row's place_id in place according to idRemap,
+// for rows whose moz_places parent had to be re-inserted under a new id (see
+// restorePlaces). Rows whose place_id isn't in idRemap are left untouched.
+func remapPlaceID(rows []map[string]interface{}, idRemap map[int64]int64) {
+	if len(idRemap) == 0 {
+		return
+	}
+	for _, row := range rows {
+		origID, ok := toInt64(row["place_id"])
+		if !ok {
+			continue
+		}
+		if newID, ok := idRemap[origID]; ok {
+			row["place_id"] = newID
+		}
+	}
+}
+
+// toInt64 extracts an int64 from a backup row value, which is a float64
+// after a round trip through encoding/json.
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// restoreRow inserts a single backed-up row into table, preserving its
+// original column values (including its id, where the table has one). It
+// reports whether the row was actually inserted (INSERT OR IGNORE silently
+// does nothing instead of erroring when a primary key or unique constraint
+// already exists, so the caller can't tell success from a no-op just by
+// checking err) along with the row's rowid, for callers that dropped the
+// original id and need to know what SQLite assigned instead.
+func restoreRow(tx *sql.Tx, table string, row map[string]interface{}) (inserted bool, rowID int64, err error) {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	placeholders := make([]string, len(cols))
+	args := make([]interface{}, len(cols))
+	for i, col := range cols {
+		placeholders[i] = "?"
+		args[i] = row[col]
+	}
+
+	insertSQL := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), strings.Join(placeholders, ", "))
+	result, err := tx.Exec(insertSQL, args...)
+	if err != nil {
+		return false, 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, 0, err
+	}
+	if affected == 0 {
+		return false, 0, nil
+	}
+	rowID, err = result.LastInsertId()
+	return true, rowID, err
+}
+
+// newPlacesGUID generates a 12-character guid in the same shape Firefox's
+// PlacesUtils.history.makeGuid() produces: 9 random bytes, base64url-encoded
+// without padding.
+func newPlacesGUID() (string, error) {
+	buf := make([]byte, 9)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate replacement guid: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// rowsToMaps runs query and returns each result row as a map of column name
+// to value. Using maps instead of a fixed struct keeps the backup format
+// resilient to the places.sqlite schema gaining or losing columns.
+func rowsToMaps(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestRestoreRowsCountsOnlyActualInserts guards against a regression where
+// performUndo counted every backed-up row as "restored" even when INSERT OR
+// IGNORE silently skipped it because a row with the same primary key already
+// existed, overstating what was actually recovered.
+func TestRestoreRowsCountsOnlyActualInserts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, guid TEXT UNIQUE, val TEXT)"); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t (id, guid, val) VALUES (1, 'existing', 'already here')"); err != nil {
+		t.Fatalf("seeding table: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	rows := []map[string]interface{}{
+		{"id": int64(1), "guid": "existing", "val": "conflicting"}, // should be skipped
+		{"id": int64(2), "guid": "new", "val": "fresh"},            // should be inserted
+	}
+	restored, skipped, err := restoreRows(tx, "t", rows, nil)
+	if err != nil {
+		t.Fatalf("restoreRows: %v", err)
+	}
+	if restored != 1 || skipped != 1 {
+		t.Fatalf("expected 1 restored and 1 skipped, got restored=%d skipped=%d", restored, skipped)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+// TestRestorePlacesRemapsDependentRows guards against a regression where a
+// moz_places row that had to be re-inserted under a regenerated guid and id
+// (because its original guid was reused by an unrelated place) left its
+// backed-up moz_historyvisits/moz_annos/moz_places_metadata rows pointing at
+// the old, now-unrelated place_id instead of following it to the new id.
+func TestRestorePlacesRemapsDependentRows(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE moz_places (id INTEGER PRIMARY KEY, guid TEXT UNIQUE, url TEXT)"); err != nil {
+		t.Fatalf("creating moz_places: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE moz_historyvisits (id INTEGER PRIMARY KEY, place_id INTEGER, visit_date INTEGER)"); err != nil {
+		t.Fatalf("creating moz_historyvisits: %v", err)
+	}
+	// A different place has since taken over id=1 and the backed-up guid.
+	if _, err := db.Exec("INSERT INTO moz_places (id, guid, url) VALUES (1, 'reused-guid', 'https://unrelated.example/')"); err != nil {
+		t.Fatalf("seeding moz_places: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	places := []map[string]interface{}{
+		{"id": float64(1), "guid": "reused-guid", "url": "https://old.example/"},
+	}
+	_, _, idRemap, err := restorePlaces(tx, places)
+	if err != nil {
+		t.Fatalf("restorePlaces: %v", err)
+	}
+	if len(idRemap) != 1 {
+		t.Fatalf("expected the reinserted place's id to be remapped, got %v", idRemap)
+	}
+	newID, ok := idRemap[1]
+	if !ok || newID == 1 {
+		t.Fatalf("expected id 1 to remap to a new id, got %v ok=%v", newID, ok)
+	}
+
+	visits := []map[string]interface{}{
+		{"id": float64(1), "place_id": float64(1), "visit_date": float64(12345)},
+	}
+	remapPlaceID(visits, idRemap)
+	if _, _, err := restoreRow(tx, "moz_historyvisits", visits[0]); err != nil {
+		t.Fatalf("restoreRow moz_historyvisits: %v", err)
+	}
+
+	var visitPlaceID int64
+	if err := tx.QueryRow("SELECT place_id FROM moz_historyvisits WHERE id = 1").Scan(&visitPlaceID); err != nil {
+		t.Fatalf("querying restored visit: %v", err)
+	}
+	if visitPlaceID != newID {
+		t.Fatalf("expected restored visit's place_id to follow the remap to %d, got %d", newID, visitPlaceID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+// TestRestorePlacesRetriesOnPureIDCollision guards against a regression
+// where restorePlaces only retried with a fresh id+guid when the original
+// guid was provably taken, so a row whose id (but not guid) collided with
+// an unrelated place inserted since the backup — the common case, since
+// moz_places.id has no AUTOINCREMENT and SQLite is free to reuse it — was
+// left un-restored and miscounted as "already existed" instead of dropped.
+func TestRestorePlacesRetriesOnPureIDCollision(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE moz_places (id INTEGER PRIMARY KEY, guid TEXT UNIQUE, url TEXT)"); err != nil {
+		t.Fatalf("creating moz_places: %v", err)
+	}
+	// An unrelated place has since taken over id=1, but its guid is not the
+	// one the backup recorded.
+	if _, err := db.Exec("INSERT INTO moz_places (id, guid, url) VALUES (1, 'unrelated-guid', 'https://unrelated.example/')"); err != nil {
+		t.Fatalf("seeding moz_places: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	places := []map[string]interface{}{
+		{"id": float64(1), "guid": "original-guid", "url": "https://old.example/"},
+	}
+	restored, skipped, idRemap, err := restorePlaces(tx, places)
+	if err != nil {
+		t.Fatalf("restorePlaces: %v", err)
+	}
+	if restored != 1 || skipped != 0 {
+		t.Fatalf("expected the row to be restored via retry, got restored=%d skipped=%d", restored, skipped)
+	}
+	newID, ok := idRemap[1]
+	if !ok || newID == 1 {
+		t.Fatalf("expected id 1 to remap to a new id, got %v ok=%v", newID, ok)
+	}
+
+	var url string
+	if err := tx.QueryRow("SELECT url FROM moz_places WHERE id = ?", newID).Scan(&url); err != nil {
+		t.Fatalf("querying restored place: %v", err)
+	}
+	if url != "https://old.example/" {
+		t.Fatalf("expected the restored place's url to survive the retry, got %q", url)
+	}
+}
+
+// TestRestoreRowsRetriesWithFreshIDOnCollision guards against a regression
+// where restoreRows callers for moz_historyvisits/moz_annos/
+// moz_places_metadata had no retry path at all, so a row whose id collided
+// with an unrelated row inserted since the backup was silently dropped.
+func TestRestoreRowsRetriesWithFreshIDOnCollision(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE moz_annos (id INTEGER PRIMARY KEY, place_id INTEGER, content TEXT)"); err != nil {
+		t.Fatalf("creating moz_annos: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO moz_annos (id, place_id, content) VALUES (1, 99, 'unrelated')"); err != nil {
+		t.Fatalf("seeding moz_annos: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows := []map[string]interface{}{
+		{"id": float64(1), "place_id": float64(5), "content": "backed up"},
+	}
+	restored, skipped, err := restoreRows(tx, "moz_annos", rows, retryWithFreshID)
+	if err != nil {
+		t.Fatalf("restoreRows: %v", err)
+	}
+	if restored != 1 || skipped != 0 {
+		t.Fatalf("expected the row to be restored via retry, got restored=%d skipped=%d", restored, skipped)
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM moz_annos WHERE place_id = 5 AND content = 'backed up'").Scan(&count); err != nil {
+		t.Fatalf("querying restored anno: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected the backed-up row to be restored under a new id, got count=%d", count)
+	}
+}
+
+// TestNewPlacesGUIDShape guards against a regression in the guid generator
+// used to regenerate a conflicting moz_places.guid on restore.
+func TestNewPlacesGUIDShape(t *testing.T) {
+	guid, err := newPlacesGUID()
+	if err != nil {
+		t.Fatalf("newPlacesGUID: %v", err)
+	}
+	if len(guid) != 12 {
+		t.Fatalf("expected a 12-character guid, got %q (%d chars)", guid, len(guid))
+	}
+	other, err := newPlacesGUID()
+	if err != nil {
+		t.Fatalf("newPlacesGUID: %v", err)
+	}
+	if guid == other {
+		t.Fatal("expected two calls to newPlacesGUID to produce different guids")
+	}
+}
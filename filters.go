@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+// filterOptions holds the --older-than/--newer-than/--min-visits/
+// --max-frecency flags. A zero value in any field disables that filter.
+type filterOptions struct {
+	OlderThan   time.Duration
+	NewerThan   time.Duration
+	MinVisits   int
+	MaxFrecency int
+}
+
+// anySet reports whether at least one filter is active. When it's false and
+// no word patterns are supplied either, there is nothing to clean.
+func (f filterOptions) anySet() bool {
+	return f.OlderThan > 0 || f.NewerThan > 0 || f.MinVisits > 0 || f.MaxFrecency > 0
+}
+
+// matches reports whether a moz_places row satisfies every active filter.
+// lastVisitDate is moz_places.last_visit_date, a PRTime (microseconds since
+// the Unix epoch); lastVisitValid is false for places that were never
+// visited (a NULL last_visit_date), which never satisfies an older-than or
+// newer-than filter.
+func (f filterOptions) matches(lastVisitDate int64, lastVisitValid bool, visitCount, frecency int64) bool {
+	if f.OlderThan > 0 {
+		if !lastVisitValid {
+			return false
+		}
+		cutoff := time.Now().Add(-f.OlderThan).UnixMicro()
+		if lastVisitDate >= cutoff {
+			return false
+		}
+	}
+	if f.NewerThan > 0 {
+		if !lastVisitValid {
+			return false
+		}
+		cutoff := time.Now().Add(-f.NewerThan).UnixMicro()
+		if lastVisitDate <= cutoff {
+			return false
+		}
+	}
+	if f.MinVisits > 0 && visitCount < int64(f.MinVisits) {
+		return false
+	}
+	if f.MaxFrecency > 0 && frecency > int64(f.MaxFrecency) {
+		return false
+	}
+	return true
+}
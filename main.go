@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"database/sql"
-	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,44 +12,157 @@ import (
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/stefanwuthrich/firefox-search-clean/profiles"
 )
 
 // main is the entry point of the CLI tool.
 func main() {
 	// --- 1. Setup Flags ---
-	defaultProfilePath, err := findDefaultFirefoxProfile()
-	if err != nil {
-		log.Printf("Warning: could not auto-detect default Firefox profile: %v. Please specify the path manually with --profile.", err)
+	pm, pmErr := profiles.NewProfileManager()
+	var defaultProfilePath string
+	if pmErr != nil {
+		log.Printf("Warning: could not auto-detect Firefox profiles: %v. Please specify the path manually with --profile.", pmErr)
+	} else if def, err := pm.Default(); err == nil {
+		defaultProfilePath = def.Path
 	}
 
 	profilePath := flag.String("profile", defaultProfilePath, "Path to the Firefox profile directory.")
+	profileName := flag.String("profile-name", "", "Select a profile by its name from profiles.ini instead of --profile.")
+	listProfiles := flag.Bool("list-profiles", false, "List every discovered Firefox profile and exit.")
+	allProfiles := flag.Bool("all-profiles", false, "Run the cleanup against every discovered profile in sequence.")
 	wordsFile := flag.String("words", "words.txt", "Path to the file containing words to delete (one per line).")
 	dryRun := flag.Bool("dry-run", false, "Show what would be deleted without actually deleting it.")
+	snapshot := flag.Bool("snapshot", false, "Operate on a temporary copy of places.sqlite instead of the live database, then swap it back in. Firefox must still be closed before starting; this only skips the interactive y/n prompt and guards against Firefox being relaunched mid-run.")
+	tablesFlag := flag.String("tables", "places", "Comma-separated list of table groups to clean: places,bookmarks,cookies,forms,favicons.")
+	olderThan := flag.Duration("older-than", 0, "Only match places whose last visit is older than this (e.g. 2160h for 90 days).")
+	newerThan := flag.Duration("newer-than", 0, "Only match places whose last visit is newer than this.")
+	minVisits := flag.Int("min-visits", 0, "Only match places visited at least this many times.")
+	maxFrecency := flag.Int("max-frecency", 0, "Only match places with frecency at or below this value (0 disables the check).")
+	undoFile := flag.String("undo", "", "Path to a cleanup-*.json backup file to restore into the profile, then exit.")
+	noBackup := flag.Bool("no-backup", false, "Skip writing a JSON backup of matched rows before deleting them.")
 	flag.Parse()
 
-	if *profilePath == "" {
-		log.Fatalf("Error: Firefox profile path is required. Please specify it using the --profile flag.")
+	tables, err := parseTables(*tablesFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	filters := filterOptions{
+		OlderThan:   *olderThan,
+		NewerThan:   *newerThan,
+		MinVisits:   *minVisits,
+		MaxFrecency: *maxFrecency,
+	}
+
+	if *listProfiles {
+		if pmErr != nil {
+			log.Fatalf("Error: could not discover profiles: %v", pmErr)
+		}
+		for _, p := range pm.Profiles {
+			marker := ""
+			if p.IsDefault {
+				marker = " (default)"
+			}
+			fmt.Printf("%s%s\n  Path: %s\n", p.Name, marker, p.Path)
+		}
+		return
+	}
+
+	var targets []string
+	switch {
+	case *allProfiles:
+		if pmErr != nil {
+			log.Fatalf("Error: could not discover profiles: %v", pmErr)
+		}
+		for _, p := range pm.Profiles {
+			targets = append(targets, p.Path)
+		}
+	case *profileName != "":
+		if pmErr != nil {
+			log.Fatalf("Error: could not discover profiles: %v", pmErr)
+		}
+		p, err := pm.ByName(*profileName)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		targets = []string{p.Path}
+	default:
+		if *profilePath == "" {
+			log.Fatalf("Error: Firefox profile path is required. Please specify it using the --profile flag.")
+		}
+		targets = []string{*profilePath}
+	}
+
+	if *undoFile != "" {
+		if len(targets) != 1 {
+			log.Fatalf("Error: --undo restores into a single profile; use --profile or --profile-name, not --all-profiles.")
+		}
+		if err := performUndo(targets[0], *undoFile); err != nil {
+			log.Fatalf("Error restoring backup: %v", err)
+		}
+		fmt.Println("\n✅ Undo complete.")
+		return
+	}
+
+	// --- 3. Read Words ---
+	var words []string
+	if _, statErr := os.Stat(*wordsFile); statErr == nil {
+		words, err = readWordsFromFile(*wordsFile)
+		if err != nil {
+			log.Fatalf("Error reading words file: %v", err)
+		}
+	} else if !filters.anySet() {
+		log.Fatalf("Words file '%s' not found, and no --older-than/--newer-than/--min-visits/--max-frecency filter was given. Nothing to do.", *wordsFile)
+	}
+	if len(words) == 0 && !filters.anySet() {
+		log.Fatalf("No words found in '%s'. Nothing to do.", *wordsFile)
+	}
+	if len(words) > 0 {
+		fmt.Printf("Loaded %d words to search for.\n", len(words))
+	}
+
+	for _, target := range targets {
+		if err := runCleanupForProfile(target, words, filters, *dryRun, *snapshot, !*noBackup, tables); err != nil {
+			log.Fatalf("An error occurred during cleanup of %s: %v", target, err)
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("\n✅ Dry run complete. No changes were made.")
+	} else {
+		fmt.Println("\n✅ History and autocomplete cleanup complete.")
 	}
+}
 
-	dbPath := filepath.Join(*profilePath, "places.sqlite")
+// runCleanupForProfile runs the full pre-flight checks and cleanup pass
+// against a single profile directory. When snapshot is true, the cleanup
+// runs against a temporary copy of places.sqlite instead of the live
+// database, skipping the interactive "Firefox is running" prompt below in
+// favor of copyPlacesDB/swapPlacesDB's own lock checks. Firefox still needs
+// to be closed before the run starts.
+func runCleanupForProfile(profilePath string, words []string, filters filterOptions, dryRun, snapshot, backup bool, tables []string) error {
+	dbPath := filepath.Join(profilePath, "places.sqlite")
 
 	// --- 2. Pre-flight Checks ---
 	fmt.Println("🧹 Firefox History Cleaner")
 	fmt.Println("---------------------------")
-	fmt.Printf("Profile Path: %s\n", *profilePath)
+	fmt.Printf("Profile Path: %s\n", profilePath)
 	fmt.Printf("Database: %s\n", dbPath)
-	fmt.Printf("Words File: %s\n", *wordsFile)
-	fmt.Printf("Dry Run Mode: %t\n", *dryRun)
+	fmt.Printf("Dry Run Mode: %t\n", dryRun)
+	fmt.Printf("Snapshot Mode: %t\n", snapshot)
 	fmt.Println("---------------------------")
 
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		log.Fatalf("Error: Firefox database 'places.sqlite' not found at the specified path.")
+		return fmt.Errorf("Firefox database 'places.sqlite' not found at the specified path")
+	}
+
+	if snapshot {
+		return runCleanupOnSnapshot(profilePath, words, filters, dryRun, backup, tables)
 	}
 
 	// Check for a lock file. This is crucial.
-	lockFilePath := filepath.Join(*profilePath, ".parentlock")
+	lockFilePath := filepath.Join(profilePath, ".parentlock")
 	if runtime.GOOS == "windows" {
-		lockFilePath = filepath.Join(*profilePath, "parent.lock")
+		lockFilePath = filepath.Join(profilePath, "parent.lock")
 	}
 	if _, err := os.Stat(lockFilePath); err == nil {
 		log.Println("🔴 ERROR: Firefox appears to be running. Please close Firefox completely before running this tool to avoid database corruption.")
@@ -63,34 +175,82 @@ func main() {
 		}
 	}
 
-	// --- 3. Read Words ---
-	words, err := readWordsFromFile(*wordsFile)
-	if err != nil {
-		log.Fatalf("Error reading words file: %v", err)
-	}
-	if len(words) == 0 {
-		log.Fatalf("No words found in '%s'. Nothing to do.", *wordsFile)
-	}
-	fmt.Printf("Loaded %d words to search for.\n", len(words))
-
 	// --- 4. Connect to DB ---
 	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", dbPath))
 	if err != nil {
-		log.Fatalf("Error opening database: %v", err)
+		return fmt.Errorf("error opening database: %w", err)
 	}
 	defer db.Close()
 
 	// --- 5. Run Cleanup ---
-	err = cleanupHistory(db, words, *dryRun)
+	if contains(tables, "places") {
+		if err := cleanupHistory(db, words, filters, dryRun, backup, profilePath); err != nil {
+			return err
+		}
+	}
+	return cleanupAuxiliaryTables(profilePath, db, words, dryRun, tables)
+}
+
+// runCleanupOnSnapshot copies places.sqlite into a temporary directory,
+// runs the cleanup against the copy, compacts it with VACUUM, and swaps it
+// back into place. Firefox never sees a half-modified database.
+func runCleanupOnSnapshot(profilePath string, words []string, filters filterOptions, dryRun, backup bool, tables []string) error {
+	fmt.Println("\n📸 Copying places.sqlite to a temporary snapshot...")
+	tempDir, err := copyPlacesDB(profilePath)
 	if err != nil {
-		log.Fatalf("An error occurred during cleanup: %v", err)
+		return fmt.Errorf("could not create snapshot: %w", err)
 	}
+	defer os.RemoveAll(tempDir)
 
-	if *dryRun {
-		fmt.Println("\n✅ Dry run complete. No changes were made.")
-	} else {
-		fmt.Println("\n✅ History and autocomplete cleanup complete.")
+	snapshotDBPath := filepath.Join(tempDir, "places.sqlite")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", snapshotDBPath))
+	if err != nil {
+		return fmt.Errorf("error opening snapshot database: %w", err)
+	}
+	defer db.Close()
+
+	if contains(tables, "places") {
+		if err := cleanupHistory(db, words, filters, dryRun, backup, profilePath); err != nil {
+			return err
+		}
+	}
+	if contains(tables, "bookmarks") {
+		matchers, err := parseMatchers(words)
+		if err != nil {
+			return fmt.Errorf("error parsing word patterns: %w", err)
+		}
+		if err := cleanupBookmarks(db, matchers, dryRun); err != nil {
+			return fmt.Errorf("error cleaning bookmarks: %w", err)
+		}
+	}
+
+	// Cookies/forms/favicons live in their own sqlite files that --snapshot
+	// doesn't cover; clean those against the live profile directly.
+	var sidecarTables []string
+	for _, t := range tables {
+		if t != "places" && t != "bookmarks" {
+			sidecarTables = append(sidecarTables, t)
+		}
+	}
+
+	if dryRun {
+		return cleanupAuxiliaryTables(profilePath, nil, words, dryRun, sidecarTables)
 	}
+
+	fmt.Println("🗜️  Compacting snapshot with VACUUM...")
+	if err := vacuumDB(db); err != nil {
+		return fmt.Errorf("could not vacuum snapshot: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("could not close snapshot database before swap: %w", err)
+	}
+
+	fmt.Println("🔁 Swapping compacted snapshot back into the profile...")
+	if err := swapPlacesDB(profilePath, tempDir); err != nil {
+		return fmt.Errorf("could not swap snapshot back into place: %w", err)
+	}
+
+	return cleanupAuxiliaryTables(profilePath, nil, words, dryRun, sidecarTables)
 }
 
 func readWordsFromFile(path string) ([]string, error) {
@@ -112,20 +272,22 @@ func readWordsFromFile(path string) ([]string, error) {
 }
 
 // cleanupHistory finds and deletes history from moz_places, moz_historyvisits, and moz_inputhistory.
-func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
-	// --- Find Places to Delete ---
-	var placesWhereClauses []string
-	var placesArgs []interface{}
-	for _, word := range words {
-		placesWhereClauses = append(placesWhereClauses, "url LIKE ? OR title LIKE ?")
-		likePattern := "%" + word + "%"
-		placesArgs = append(placesArgs, likePattern, likePattern)
+// Word matching is delegated to the Matcher pipeline (see matcher.go) so that
+// regex, host, and glob patterns work alongside plain substrings; time-range
+// and visit-count filters (see filters.go) further narrow the moz_places
+// results, and can be used on their own with no words at all.
+func cleanupHistory(db *sql.DB, words []string, filters filterOptions, dryRun, backup bool, profilePath string) error {
+	matchers, err := parseMatchers(words)
+	if err != nil {
+		return fmt.Errorf("error parsing word patterns: %w", err)
+	}
+	if len(matchers) == 0 && !filters.anySet() {
+		return fmt.Errorf("no words and no filters given; nothing to clean")
 	}
-	placesWhereSQL := strings.Join(placesWhereClauses, " OR ")
 
-	findSQL := "SELECT id, url, title FROM moz_places WHERE " + placesWhereSQL
+	// --- Find Places to Delete ---
 	fmt.Println("\n🔎 Searching for matching history entries...")
-	rows, err := db.Query(findSQL, placesArgs...)
+	rows, err := db.Query("SELECT id, url, title, last_visit_date, visit_count, frecency FROM moz_places")
 	if err != nil {
 		return fmt.Errorf("error querying for places to delete: %w", err)
 	}
@@ -136,36 +298,48 @@ func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
 	for rows.Next() {
 		var id int64
 		var url, title sql.NullString
-		if err := rows.Scan(&id, &url, &title); err != nil {
+		var lastVisitDate sql.NullInt64
+		var visitCount, frecency int64
+		if err := rows.Scan(&id, &url, &title, &lastVisitDate, &visitCount, &frecency); err != nil {
 			return fmt.Errorf("error scanning row: %w", err)
 		}
+		if len(matchers) > 0 && !matchAny(matchers, url.String, title.String) {
+			continue
+		}
+		if !filters.matches(lastVisitDate.Int64, lastVisitDate.Valid, visitCount, frecency) {
+			continue
+		}
 		placeIDs = append(placeIDs, id)
 		entriesToDelete = append(entriesToDelete, [2]string{url.String, title.String})
 	}
-
-	// Also check for autocomplete entries to report them in the dry run
-	var inputWhereClauses []string
-	var inputArgs []interface{}
-	for _, word := range words {
-		inputWhereClauses = append(inputWhereClauses, "input LIKE ?")
-		inputArgs = append(inputArgs, "%"+word+"%")
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading places rows: %w", err)
 	}
-	inputWhereSQL := strings.Join(inputWhereClauses, " OR ")
-	findInputSQL := "SELECT input FROM moz_inputhistory WHERE " + inputWhereSQL
-
-	inputRows, err := db.Query(findInputSQL, inputArgs...)
-	if err != nil {
-		return fmt.Errorf("error querying for autocomplete entries: %w", err)
-	}
-	defer inputRows.Close()
 
+	// Also check for autocomplete entries to report them in the dry run.
+	// moz_inputhistory carries no timestamp or visit count, so only word
+	// matches apply here; a pure filter-based run leaves it untouched.
 	var autocompleteToDelete []string
-	for inputRows.Next() {
-		var input string
-		if err := inputRows.Scan(&input); err != nil {
-			return fmt.Errorf("error scanning input row: %w", err)
+	if len(matchers) > 0 {
+		inputRows, err := db.Query("SELECT input FROM moz_inputhistory")
+		if err != nil {
+			return fmt.Errorf("error querying for autocomplete entries: %w", err)
+		}
+		defer inputRows.Close()
+
+		for inputRows.Next() {
+			var input string
+			if err := inputRows.Scan(&input); err != nil {
+				return fmt.Errorf("error scanning input row: %w", err)
+			}
+			if !matchAny(matchers, input, input) {
+				continue
+			}
+			autocompleteToDelete = append(autocompleteToDelete, input)
+		}
+		if err := inputRows.Err(); err != nil {
+			return fmt.Errorf("error reading autocomplete rows: %w", err)
 		}
-		autocompleteToDelete = append(autocompleteToDelete, input)
 	}
 
 	if len(placeIDs) == 0 && len(autocompleteToDelete) == 0 {
@@ -190,6 +364,16 @@ func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
 		return nil
 	}
 
+	if backup {
+		backupPath, err := exportBackup(db, profilePath, placeIDs, autocompleteToDelete)
+		if err != nil {
+			return fmt.Errorf("error writing backup: %w", err)
+		}
+		if backupPath != "" {
+			fmt.Printf("💾 Backed up matched rows to %s (restore with --undo %s).\n", backupPath, backupPath)
+		}
+	}
+
 	fmt.Println("\n🗑️ Deleting entries...")
 	tx, err := db.Begin()
 	if err != nil {
@@ -214,6 +398,17 @@ func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
 		visitsDeleted, _ := result.RowsAffected()
 		fmt.Printf("- Deleted %d individual visit records (from moz_historyvisits).\n", visitsDeleted)
 
+		// STEP 1b: Delete place-level annotations and activity-stream metadata
+		// that reference the places we're about to remove.
+		if _, err := tx.Exec("DELETE FROM moz_annos WHERE place_id IN ("+idPlaceholders+")", idArgs...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete from moz_annos: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM moz_places_metadata WHERE place_id IN ("+idPlaceholders+")", idArgs...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete from moz_places_metadata: %w", err)
+		}
+
 		// STEP 2: Delete from moz_places
 		deletePlacesSQL := "DELETE FROM moz_places WHERE id IN (" + idPlaceholders + ")"
 		result, err = tx.Exec(deletePlacesSQL, idArgs...)
@@ -223,12 +418,23 @@ func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
 		}
 		placesDeleted, _ := result.RowsAffected()
 		fmt.Printf("- Deleted %d unique URL entries (from moz_places).\n", placesDeleted)
+
+		// STEP 2b: Drop origins that no longer have any place referencing them.
+		if _, err := tx.Exec("DELETE FROM moz_origins WHERE id NOT IN (SELECT DISTINCT origin_id FROM moz_places)"); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete orphaned rows from moz_origins: %w", err)
+		}
 	}
 
 	// STEP 3: Delete from moz_inputhistory
 	// This is the new step to clear autocomplete suggestions.
 	if len(autocompleteToDelete) > 0 {
-		deleteInputSQL := "DELETE FROM moz_inputhistory WHERE " + inputWhereSQL
+		inputPlaceholders := "?" + strings.Repeat(",?", len(autocompleteToDelete)-1)
+		inputArgs := make([]interface{}, len(autocompleteToDelete))
+		for i, v := range autocompleteToDelete {
+			inputArgs[i] = v
+		}
+		deleteInputSQL := "DELETE FROM moz_inputhistory WHERE input IN (" + inputPlaceholders + ")"
 		result, err := tx.Exec(deleteInputSQL, inputArgs...)
 		if err != nil {
 			tx.Rollback()
@@ -240,86 +446,3 @@ func cleanupHistory(db *sql.DB, words []string, dryRun bool) error {
 
 	return tx.Commit()
 }
-
-func findDefaultFirefoxProfile() (string, error) {
-	var basePath, iniPath string
-	var err error
-
-	switch runtime.GOOS {
-	case "windows":
-		appData, err := os.UserConfigDir()
-		if err != nil {
-			return "", err
-		}
-		basePath = filepath.Join(appData, "Mozilla", "Firefox")
-	case "darwin":
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		basePath = filepath.Join(homeDir, "Library", "Application Support", "Firefox")
-	case "linux":
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		basePath = filepath.Join(homeDir, ".mozilla", "firefox")
-	default:
-		return "", errors.New("unsupported operating system")
-	}
-
-	iniPath = filepath.Join(basePath, "profiles.ini")
-	if _, err := os.Stat(iniPath); err != nil {
-		return "", fmt.Errorf("profiles.ini not found at %s", iniPath)
-	}
-
-	file, err := os.Open(iniPath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var profileDir string
-	isRelative := true
-	inProfileBlock := false
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "[Install") {
-			inProfileBlock = true
-		}
-		if inProfileBlock && strings.HasPrefix(line, "Default=") {
-			profileDir = strings.SplitN(line, "=", 2)[1]
-			return filepath.Join(basePath, profileDir), nil
-		}
-		if strings.HasPrefix(line, "[") && !strings.HasPrefix(line, "[Install") {
-			inProfileBlock = false
-		}
-	}
-
-	file.Seek(0, 0)
-	scanner = bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "[Profile0]") {
-			inProfileBlock = true
-		}
-		if inProfileBlock && strings.HasPrefix(line, "Path=") {
-			profileDir = strings.SplitN(line, "=", 2)[1]
-		}
-		if inProfileBlock && strings.HasPrefix(line, "IsRelative=") {
-			isRelative = strings.TrimSpace(strings.SplitN(line, "=", 2)[1]) == "1"
-		}
-	}
-
-	if profileDir != "" {
-		if isRelative {
-			return filepath.Join(basePath, profileDir), nil
-		}
-		return profileDir, nil
-	}
-
-	return "", errors.New("could not determine default profile from profiles.ini")
-}
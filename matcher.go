@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// matcher decides whether a URL/title pair from words.txt should be treated
+// as a match. Plain lines in words.txt become literalMatchers; lines
+// prefixed with "re:", "host:", or "glob:" select the other kinds.
+type matcher interface {
+	Match(url, title string) bool
+}
+
+// literalMatcher is a plain, case-insensitive substring match, like the
+// original LIKE '%word%' query. Unlike LIKE, "%" and "_" in the word are
+// matched literally rather than as wildcards.
+type literalMatcher struct {
+	pattern string
+}
+
+func (m literalMatcher) Match(url, title string) bool {
+	p := strings.ToLower(m.pattern)
+	return strings.Contains(strings.ToLower(url), p) || strings.Contains(strings.ToLower(title), p)
+}
+
+// regexMatcher runs a Go regexp against both the url and the title.
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexMatcher) Match(url, title string) bool {
+	return m.re.MatchString(url) || m.re.MatchString(title)
+}
+
+// hostMatcher matches a URL whose eTLD+1 equals domain, or whose host is a
+// subdomain of it (e.g. "host:example.com" matches "www.example.com" but
+// not "notexample.com"). rawURL is usually a full URL, but callers that
+// only have a bare hostname on hand (e.g. moz_cookies.host) can pass that
+// directly too.
+type hostMatcher struct {
+	domain string
+}
+
+func (m hostMatcher) Match(rawURL, _ string) bool {
+	host := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		if h := parsed.Hostname(); h != "" {
+			host = h
+		}
+	}
+	if host == "" {
+		return false
+	}
+	if host == m.domain || strings.HasSuffix(host, "."+m.domain) {
+		return true
+	}
+	etldPlusOne, err := publicsuffix.EffectiveTLDPlusOne(host)
+	return err == nil && etldPlusOne == m.domain
+}
+
+// globMatcher matches the url or title against a shell-style glob pattern,
+// where "*" matches any run of characters (including "/") and "?" matches
+// any single character. path.Match would be the obvious stdlib choice, but
+// it treats "/" as a path separator that "*" can't cross, so a pattern like
+// "glob:*example*" would never match a full URL such as
+// "https://example.com/old".
+type globMatcher struct {
+	re *regexp.Regexp
+}
+
+func newGlobMatcher(pattern string) globMatcher {
+	return globMatcher{re: regexp.MustCompile("(?s)^" + globToRegexp(pattern) + "$")}
+}
+
+func (m globMatcher) Match(url, title string) bool {
+	return m.re.MatchString(url) || m.re.MatchString(title)
+}
+
+// globToRegexp translates a shell-style glob into an equivalent regexp
+// pattern, escaping everything except "*" and "?".
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// parseMatchers converts words.txt lines into matchers, honoring the
+// "re:", "host:", and "glob:" line prefixes; a plain line becomes a
+// literalMatcher.
+func parseMatchers(words []string) ([]matcher, error) {
+	var matchers []matcher
+	for _, word := range words {
+		switch {
+		case strings.HasPrefix(word, "re:"):
+			pattern := strings.TrimPrefix(word, "re:")
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+			}
+			matchers = append(matchers, regexMatcher{re: re})
+		case strings.HasPrefix(word, "host:"):
+			matchers = append(matchers, hostMatcher{domain: strings.TrimPrefix(word, "host:")})
+		case strings.HasPrefix(word, "glob:"):
+			matchers = append(matchers, newGlobMatcher(strings.TrimPrefix(word, "glob:")))
+		default:
+			matchers = append(matchers, literalMatcher{pattern: word})
+		}
+	}
+	return matchers, nil
+}
+
+// matchAny reports whether any matcher matches the given url/title pair.
+func matchAny(matchers []matcher, url, title string) bool {
+	for _, m := range matchers {
+		if m.Match(url, title) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// TestGlobMatcherMatchesAcrossSlashes guards against a regression where the
+// glob matcher was implemented with path.Match, which treats "/" as a path
+// separator that "*" cannot cross, so "glob:*example*" never matched a full
+// URL like "https://example.com/old".
+func TestGlobMatcherMatchesAcrossSlashes(t *testing.T) {
+	m := newGlobMatcher("*example*")
+	if !m.Match("https://example.com/old", "") {
+		t.Fatal("expected glob:*example* to match a URL containing a slash")
+	}
+	if m.Match("https://other.com/page", "") {
+		t.Fatal("did not expect glob:*example* to match an unrelated URL")
+	}
+}
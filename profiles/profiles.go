@@ -0,0 +1,203 @@
+// Package profiles discovers and parses Firefox profiles.ini files so the
+// cleaner can target the right profile instead of guessing.
+package profiles
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Profile describes a single entry parsed from a profiles.ini [Profile*]
+// section, resolved to an absolute path on disk.
+type Profile struct {
+	Name       string
+	Path       string
+	IsRelative bool
+	IsDefault  bool
+	LastUsed   int64
+}
+
+// ProfileManager parses one or more profiles.ini files (covering standard,
+// Flatpak and Snap install locations) and exposes the profiles found.
+type ProfileManager struct {
+	// IniPaths are the profiles.ini files that were found and parsed.
+	IniPaths []string
+	Profiles []Profile
+}
+
+// NewProfileManager locates every profiles.ini reachable for this OS and
+// parses it into a ProfileManager. It is not an error for some candidate
+// locations to be missing; only if none are found does it return an error.
+func NewProfileManager() (*ProfileManager, error) {
+	pm := &ProfileManager{}
+
+	for _, base := range candidateBasePaths() {
+		iniPath := filepath.Join(base, "profiles.ini")
+		if _, err := os.Stat(iniPath); err != nil {
+			continue
+		}
+		profiles, err := parseProfilesIni(iniPath, base)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", iniPath, err)
+		}
+		pm.IniPaths = append(pm.IniPaths, iniPath)
+		pm.Profiles = append(pm.Profiles, profiles...)
+	}
+
+	if len(pm.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles.ini found in any known Firefox location")
+	}
+
+	return pm, nil
+}
+
+// candidateBasePaths returns every directory that might contain a
+// profiles.ini, covering standard installs as well as Flatpak/Snap packaging
+// on Linux and the various Firefox flavors on macOS/Windows.
+func candidateBasePaths() []string {
+	var bases []string
+
+	switch runtime.GOOS {
+	case "windows":
+		appData, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		for _, flavor := range []string{"Firefox", "Firefox Developer Edition", "Nightly"} {
+			bases = append(bases, filepath.Join(appData, "Mozilla", flavor))
+		}
+	case "darwin":
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		support := filepath.Join(homeDir, "Library", "Application Support")
+		for _, flavor := range []string{"Firefox", "Firefox Developer Edition", "Firefox Nightly"} {
+			bases = append(bases, filepath.Join(support, flavor))
+		}
+	case "linux":
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		bases = append(bases,
+			filepath.Join(homeDir, ".mozilla", "firefox"),
+			filepath.Join(homeDir, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox"),
+			filepath.Join(homeDir, "snap", "firefox", "common", ".mozilla", "firefox"),
+		)
+	}
+
+	return bases
+}
+
+// parseProfilesIni parses the [Install*] and [Profile*] sections of a single
+// profiles.ini file, resolving relative paths against base.
+func parseProfilesIni(iniPath, base string) ([]Profile, error) {
+	file, err := os.Open(iniPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	type section struct {
+		name   string
+		values map[string]string
+	}
+	var sections []section
+	var current *section
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sections = append(sections, section{name: line[1 : len(line)-1], values: map[string]string{}})
+			current = &sections[len(sections)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		current.values[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// An [Install*] section's Default= points at the path of the profile
+	// that install should use; record it so we can mark IsDefault below.
+	installDefaultPath := map[string]bool{}
+	for _, s := range sections {
+		if strings.HasPrefix(s.name, "Install") {
+			if def, ok := s.values["Default"]; ok {
+				installDefaultPath[def] = true
+			}
+		}
+	}
+
+	var result []Profile
+	for _, s := range sections {
+		if !strings.HasPrefix(s.name, "Profile") {
+			continue
+		}
+		path, ok := s.values["Path"]
+		if !ok {
+			continue
+		}
+		isRelative := s.values["IsRelative"] != "0"
+		lastUsed, _ := strconv.ParseInt(s.values["KeyLastUsed"], 10, 64)
+
+		resolved := path
+		if isRelative {
+			resolved = filepath.Join(base, path)
+		}
+
+		p := Profile{
+			Name:       s.values["Name"],
+			Path:       resolved,
+			IsRelative: isRelative,
+			LastUsed:   lastUsed,
+		}
+		if s.values["Default"] == "1" || installDefaultPath[path] {
+			p.IsDefault = true
+		}
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// Default returns the profile marked as default, falling back to the first
+// profile found if none is explicitly marked.
+func (pm *ProfileManager) Default() (Profile, error) {
+	for _, p := range pm.Profiles {
+		if p.IsDefault {
+			return p, nil
+		}
+	}
+	if len(pm.Profiles) > 0 {
+		return pm.Profiles[0], nil
+	}
+	return Profile{}, fmt.Errorf("no profiles available")
+}
+
+// ByName returns the profile whose Name matches (case-insensitively).
+func (pm *ProfileManager) ByName(name string) (Profile, error) {
+	for _, p := range pm.Profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("no profile named %q found", name)
+}
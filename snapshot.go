@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// placesDBFiles are the SQLite main file plus its -wal and -shm sidecars.
+// All three must travel together for a snapshot to be consistent.
+var placesDBFiles = []string{"places.sqlite", "places.sqlite-wal", "places.sqlite-shm"}
+
+// firefoxLockPath returns the path to Firefox's own profile lock file, which
+// only exists while Firefox is running.
+func firefoxLockPath(profilePath string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(profilePath, "parent.lock")
+	}
+	return filepath.Join(profilePath, ".parentlock")
+}
+
+// copyPlacesDB copies places.sqlite and its sidecars from profilePath into a
+// fresh temporary directory so the cleanup can run against a snapshot
+// instead of the live database. It requires Firefox to already be closed:
+// copying a live, open places.sqlite can race with Firefox's own writes, and
+// swapPlacesDB can only detect Firefox coming back mid-run, not having been
+// running the whole time. The caller owns the returned directory and must
+// remove it once done.
+func copyPlacesDB(profilePath string) (tempDir string, err error) {
+	if _, err := os.Stat(firefoxLockPath(profilePath)); err == nil {
+		return "", fmt.Errorf("Firefox appears to be running (profile lock file present); please close Firefox before using --snapshot")
+	}
+
+	lockPath := filepath.Join(profilePath, "places.sqlite-clean.lock")
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", fmt.Errorf("could not open lock file %s: %w", lockPath, err)
+	}
+	defer lockFile.Close()
+
+	if runtime.GOOS != "windows" {
+		if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			return "", fmt.Errorf("could not lock %s (another cleanup run may be in progress): %w", lockPath, err)
+		}
+		defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	}
+
+	// Created inside the profile directory so swapPlacesDB's os.Rename stays
+	// on one filesystem.
+	tempDir, err = os.MkdirTemp(profilePath, ".firefox-search-clean-")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp dir: %w", err)
+	}
+
+	for _, name := range placesDBFiles {
+		src := filepath.Join(profilePath, name)
+		if _, statErr := os.Stat(src); os.IsNotExist(statErr) {
+			continue
+		}
+		if err := copyFile(src, filepath.Join(tempDir, name)); err != nil {
+			os.RemoveAll(tempDir)
+			return "", fmt.Errorf("could not copy %s: %w", name, err)
+		}
+	}
+
+	return tempDir, nil
+}
+
+// swapPlacesDB atomically renames the (now compacted) snapshot files in
+// tempDir over the live files in profilePath. It aborts without touching
+// anything if Firefox's own lock file has reappeared since copyPlacesDB
+// confirmed it was absent, since that means Firefox was relaunched mid-run.
+func swapPlacesDB(profilePath, tempDir string) error {
+	if _, err := os.Stat(firefoxLockPath(profilePath)); err == nil {
+		return fmt.Errorf("Firefox's lock file reappeared during the snapshot run; aborting to avoid corrupting the live database")
+	}
+
+	for _, name := range placesDBFiles {
+		src := filepath.Join(tempDir, name)
+		dst := filepath.Join(profilePath, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			// The snapshot has no such sidecar (closing it checkpointed the
+			// WAL); drop the live one so it isn't replayed onto the new file.
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("could not remove stale %s: %w", name, err)
+			}
+			continue
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("could not swap in %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// vacuumDB compacts db, shrinking it to reclaim the space freed by deletions.
+func vacuumDB(db *sql.DB) error {
+	_, err := db.Exec("VACUUM")
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
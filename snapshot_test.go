@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestCopyPlacesDBRefusesWhileFirefoxRunning guards against a regression
+// where --snapshot claimed to avoid closing Firefox first, but copyPlacesDB
+// never checked Firefox's own lock file before copying, so the lock was
+// always still present by the time swapPlacesDB ran and the whole snapshot
+// (copy, cleanup, VACUUM) was thrown away at the final swap if Firefox had
+// been open the entire time.
+func TestCopyPlacesDBRefusesWhileFirefoxRunning(t *testing.T) {
+	profileDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(profileDir, "places.sqlite"), []byte("not a real db"), 0600); err != nil {
+		t.Fatalf("seeding places.sqlite: %v", err)
+	}
+
+	lockName := ".parentlock"
+	if runtime.GOOS == "windows" {
+		lockName = "parent.lock"
+	}
+	if err := os.WriteFile(filepath.Join(profileDir, lockName), nil, 0600); err != nil {
+		t.Fatalf("seeding lock file: %v", err)
+	}
+
+	if _, err := copyPlacesDB(profileDir); err == nil {
+		t.Fatal("expected copyPlacesDB to refuse to run while Firefox's lock file is present")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validTables enumerates the groups accepted by --tables.
+var validTables = map[string]bool{
+	"places":    true,
+	"bookmarks": true,
+	"cookies":   true,
+	"forms":     true,
+	"favicons":  true,
+}
+
+// parseTables turns a comma-separated --tables value into the set of table
+// groups to clean, defaulting to just "places" when the flag is empty.
+func parseTables(raw string) ([]string, error) {
+	if raw == "" {
+		return []string{"places"}, nil
+	}
+	var tables []string
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !validTables[t] {
+			return nil, fmt.Errorf("unknown table group %q (expected one of places,bookmarks,cookies,forms,favicons)", t)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+func contains(tables []string, name string) bool {
+	for _, t := range tables {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupAuxiliaryTables runs the cleanup passes that live outside
+// places.sqlite's moz_places/moz_historyvisits/moz_inputhistory trio,
+// according to which groups were requested via --tables. Bookmarks/cookies/
+// forms are word-matched through the same Matcher pipeline as cleanupHistory
+// (see matcher.go), so the "re:", "host:", and "glob:" prefixes work here too,
+// not just against history. Favicons have no title or visit data of their
+// own to match against, so cleanupFavicons instead garbage-collects entries
+// for places that no longer exist, independent of words.
+func cleanupAuxiliaryTables(profilePath string, placesDB *sql.DB, words []string, dryRun bool, tables []string) error {
+	matchers, err := parseMatchers(words)
+	if err != nil {
+		return fmt.Errorf("error parsing word patterns: %w", err)
+	}
+
+	if contains(tables, "bookmarks") {
+		if err := cleanupBookmarks(placesDB, matchers, dryRun); err != nil {
+			return fmt.Errorf("error cleaning bookmarks: %w", err)
+		}
+	}
+	if contains(tables, "forms") {
+		if err := cleanupSidecarDB(profilePath, formHistorySpec, matchers, dryRun); err != nil {
+			return fmt.Errorf("error cleaning form history: %w", err)
+		}
+	}
+	if contains(tables, "cookies") {
+		if err := cleanupSidecarDB(profilePath, cookiesSpec, matchers, dryRun); err != nil {
+			return fmt.Errorf("error cleaning cookies: %w", err)
+		}
+	}
+	if contains(tables, "favicons") {
+		if err := cleanupFavicons(profilePath, dryRun); err != nil {
+			return fmt.Errorf("error cleaning favicons: %w", err)
+		}
+	}
+	return nil
+}
+
+// cleanupBookmarks deletes moz_bookmarks rows whose title or linked
+// moz_places.url/title matches a word, along with their moz_items_annos.
+func cleanupBookmarks(db *sql.DB, matchers []matcher, dryRun bool) error {
+	if len(matchers) == 0 {
+		fmt.Println("No words given; skipping bookmarks cleanup.")
+		return nil
+	}
+
+	rows, err := db.Query("SELECT b.id, b.title, p.url, p.title FROM moz_bookmarks b LEFT JOIN moz_places p ON b.fk = p.id")
+	if err != nil {
+		return fmt.Errorf("error querying moz_bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var bookmarkIDs []int64
+	for rows.Next() {
+		var id int64
+		var bookmarkTitle, url, placeTitle sql.NullString
+		if err := rows.Scan(&id, &bookmarkTitle, &url, &placeTitle); err != nil {
+			return fmt.Errorf("error scanning bookmark row: %w", err)
+		}
+		if !matchAny(matchers, url.String, bookmarkTitle.String) && !matchAny(matchers, url.String, placeTitle.String) {
+			continue
+		}
+		bookmarkIDs = append(bookmarkIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading moz_bookmarks rows: %w", err)
+	}
+
+	if len(bookmarkIDs) == 0 {
+		fmt.Println("No matching bookmarks found.")
+		return nil
+	}
+	fmt.Printf("Found %d matching bookmark(s).\n", len(bookmarkIDs))
+	if dryRun {
+		return nil
+	}
+
+	idPlaceholders := "?" + strings.Repeat(",?", len(bookmarkIDs)-1)
+	idArgs := make([]interface{}, len(bookmarkIDs))
+	for i, id := range bookmarkIDs {
+		idArgs[i] = id
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM moz_items_annos WHERE item_id IN ("+idPlaceholders+")", idArgs...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete from moz_items_annos: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM moz_bookmarks WHERE id IN ("+idPlaceholders+")", idArgs...)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete from moz_bookmarks: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	fmt.Printf("- Deleted %d bookmark(s) (from moz_bookmarks).\n", deleted)
+
+	return tx.Commit()
+}
+
+// sidecarSpec configures cleanupSidecarDB for one sidecar database: which
+// file and table to open, the query that reads back candidate rows, and how
+// to turn a scanned row into the (url, title)-shaped pair matchAny expects.
+type sidecarSpec struct {
+	file     string
+	table    string
+	idColumn string
+	querySQL string // selects idColumn followed by the columns matchOf expects, in order
+	matchOf  func(cols []sql.NullString) (url, title string)
+	label    string
+}
+
+var formHistorySpec = sidecarSpec{
+	file:     "formhistory.sqlite",
+	table:    "moz_formhistory",
+	idColumn: "id",
+	querySQL: "SELECT id, fieldname, value FROM moz_formhistory",
+	matchOf:  func(cols []sql.NullString) (string, string) { return cols[1].String, cols[0].String },
+	label:    "form history entries",
+}
+
+var cookiesSpec = sidecarSpec{
+	file:     "cookies.sqlite",
+	table:    "moz_cookies",
+	idColumn: "id",
+	querySQL: "SELECT id, host FROM moz_cookies",
+	matchOf:  func(cols []sql.NullString) (string, string) { return cols[0].String, "" },
+	label:    "cookies",
+}
+
+// cleanupSidecarDB opens a standalone profile database (formhistory.sqlite,
+// cookies.sqlite), finds rows matching matchers, reports
+// them, and deletes them unless dryRun is set. It is a no-op, not an error,
+// if the sidecar database doesn't exist for this profile.
+func cleanupSidecarDB(profilePath string, spec sidecarSpec, matchers []matcher, dryRun bool) error {
+	if len(matchers) == 0 {
+		fmt.Printf("No words given; skipping %s.\n", spec.label)
+		return nil
+	}
+
+	dbPath := filepath.Join(profilePath, spec.file)
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fmt.Printf("Skipping %s: not found in profile.\n", spec.file)
+		return nil
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", dbPath))
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", spec.file, err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(spec.querySQL)
+	if err != nil {
+		return fmt.Errorf("error querying %s: %w", spec.table, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("error reading %s columns: %w", spec.table, err)
+	}
+
+	var matchedIDs []int64
+	for rows.Next() {
+		var id int64
+		values := make([]sql.NullString, len(cols)-1)
+		ptrs := make([]interface{}, len(cols))
+		ptrs[0] = &id
+		for i := range values {
+			ptrs[i+1] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("error scanning %s row: %w", spec.table, err)
+		}
+		url, title := spec.matchOf(values)
+		if !matchAny(matchers, url, title) {
+			continue
+		}
+		matchedIDs = append(matchedIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s rows: %w", spec.table, err)
+	}
+
+	if len(matchedIDs) == 0 {
+		fmt.Printf("No matching %s found in %s.\n", spec.label, spec.file)
+		return nil
+	}
+	fmt.Printf("Found %d matching %s in %s.\n", len(matchedIDs), spec.label, spec.file)
+	if dryRun {
+		return nil
+	}
+
+	idPlaceholders := "?" + strings.Repeat(",?", len(matchedIDs)-1)
+	idArgs := make([]interface{}, len(matchedIDs))
+	for i, id := range matchedIDs {
+		idArgs[i] = id
+	}
+	result, err := db.Exec("DELETE FROM "+spec.table+" WHERE "+spec.idColumn+" IN ("+idPlaceholders+")", idArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", spec.label, err)
+	}
+	deleted, _ := result.RowsAffected()
+	fmt.Printf("- Deleted %d %s (from %s).\n", deleted, spec.label, spec.file)
+	return nil
+}
+
+// cleanupFavicons removes moz_pages_w_icons rows in favicons.sqlite whose
+// page_url no longer matches any row in places.sqlite's moz_places — i.e.
+// favicon entries left orphaned once the place that referenced them was
+// deleted (by this run or an earlier one). Run after places.sqlite has
+// already been updated, so it always reads the current, post-cleanup set of
+// place URLs straight off disk rather than taking it as a parameter.
+func cleanupFavicons(profilePath string, dryRun bool) error {
+	dbPath := filepath.Join(profilePath, "favicons.sqlite")
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fmt.Println("Skipping favicons.sqlite: not found in profile.")
+		return nil
+	}
+
+	placeURLs, err := currentPlaceURLs(profilePath)
+	if err != nil {
+		return fmt.Errorf("error reading places.sqlite to find orphaned favicons: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", dbPath))
+	if err != nil {
+		return fmt.Errorf("error opening favicons.sqlite: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, page_url FROM moz_pages_w_icons")
+	if err != nil {
+		return fmt.Errorf("error querying moz_pages_w_icons: %w", err)
+	}
+	defer rows.Close()
+
+	var orphanIDs []int64
+	for rows.Next() {
+		var id int64
+		var pageURL sql.NullString
+		if err := rows.Scan(&id, &pageURL); err != nil {
+			return fmt.Errorf("error scanning moz_pages_w_icons row: %w", err)
+		}
+		if _, stillReferenced := placeURLs[pageURL.String]; stillReferenced {
+			continue
+		}
+		orphanIDs = append(orphanIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading moz_pages_w_icons rows: %w", err)
+	}
+
+	if len(orphanIDs) == 0 {
+		fmt.Println("No orphaned favicon entries found in favicons.sqlite.")
+		return nil
+	}
+	fmt.Printf("Found %d orphaned favicon entr(ies) in favicons.sqlite.\n", len(orphanIDs))
+	if dryRun {
+		return nil
+	}
+
+	idPlaceholders := "?" + strings.Repeat(",?", len(orphanIDs)-1)
+	idArgs := make([]interface{}, len(orphanIDs))
+	for i, id := range orphanIDs {
+		idArgs[i] = id
+	}
+	result, err := db.Exec("DELETE FROM moz_pages_w_icons WHERE id IN ("+idPlaceholders+")", idArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to delete orphaned favicon entries: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	fmt.Printf("- Deleted %d orphaned favicon entr(ies) (from favicons.sqlite).\n", deleted)
+	return nil
+}
+
+// currentPlaceURLs returns the set of URLs currently present in profilePath's
+// places.sqlite, used by cleanupFavicons to tell which favicons.sqlite rows
+// are now orphaned.
+func currentPlaceURLs(profilePath string) (map[string]struct{}, error) {
+	dbPath := filepath.Join(profilePath, "places.sqlite")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("error opening places.sqlite: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT url FROM moz_places")
+	if err != nil {
+		return nil, fmt.Errorf("error querying moz_places: %w", err)
+	}
+	defer rows.Close()
+
+	urls := make(map[string]struct{})
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, fmt.Errorf("error scanning moz_places row: %w", err)
+		}
+		urls[url] = struct{}{}
+	}
+	return urls, rows.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestCleanupSidecarDBEmptyMatchers guards against a regression where
+// running with zero words (legal since --older-than/etc. filters can stand
+// alone) built a bare "WHERE " clause and crashed with "incomplete input"
+// instead of skipping the sidecar cleanup.
+func TestCleanupSidecarDBEmptyMatchers(t *testing.T) {
+	for _, spec := range []sidecarSpec{formHistorySpec, cookiesSpec} {
+		if err := cleanupSidecarDB(t.TempDir(), spec, nil, true); err != nil {
+			t.Errorf("%s: expected no error with zero matchers, got %v", spec.label, err)
+		}
+	}
+}
+
+// TestSidecarSpecMatchOfRoutesPrefixedMatchers guards against a regression
+// where cookies/forms matched the literal word string against a hardcoded
+// LIKE clause, so a "host:" line in words.txt was never recognized as a host
+// matcher for these tables.
+func TestSidecarSpecMatchOfRoutesPrefixedMatchers(t *testing.T) {
+	matchers, err := parseMatchers([]string{"host:ads.example.com"})
+	if err != nil {
+		t.Fatalf("parseMatchers: %v", err)
+	}
+
+	host := sql.NullString{String: "ads.example.com", Valid: true}
+	url, title := cookiesSpec.matchOf([]sql.NullString{host})
+	if !matchAny(matchers, url, title) {
+		t.Error("expected host:ads.example.com to match cookie host ads.example.com")
+	}
+}
+
+// TestCleanupFaviconsRemovesOnlyOrphans guards against a regression where
+// favicons.sqlite was word-matched the same way as cookies/forms, an
+// independent pass unrelated to what --tables=places actually deleted,
+// instead of garbage-collecting favicon entries for places that no longer
+// exist (the original ask for favicons cleanup).
+func TestCleanupFaviconsRemovesOnlyOrphans(t *testing.T) {
+	profileDir := t.TempDir()
+
+	placesDB, err := sql.Open("sqlite3", "file:"+filepath.Join(profileDir, "places.sqlite"))
+	if err != nil {
+		t.Fatalf("opening places.sqlite: %v", err)
+	}
+	if _, err := placesDB.Exec("CREATE TABLE moz_places (id INTEGER PRIMARY KEY, url TEXT)"); err != nil {
+		t.Fatalf("creating moz_places: %v", err)
+	}
+	if _, err := placesDB.Exec("INSERT INTO moz_places (url) VALUES ('https://still-here.example/')"); err != nil {
+		t.Fatalf("seeding moz_places: %v", err)
+	}
+	if err := placesDB.Close(); err != nil {
+		t.Fatalf("closing places.sqlite: %v", err)
+	}
+
+	faviconsDB, err := sql.Open("sqlite3", "file:"+filepath.Join(profileDir, "favicons.sqlite"))
+	if err != nil {
+		t.Fatalf("opening favicons.sqlite: %v", err)
+	}
+	if _, err := faviconsDB.Exec("CREATE TABLE moz_pages_w_icons (id INTEGER PRIMARY KEY, page_url TEXT)"); err != nil {
+		t.Fatalf("creating moz_pages_w_icons: %v", err)
+	}
+	if _, err := faviconsDB.Exec("INSERT INTO moz_pages_w_icons (page_url) VALUES ('https://still-here.example/'), ('https://deleted-long-ago.example/')"); err != nil {
+		t.Fatalf("seeding moz_pages_w_icons: %v", err)
+	}
+	if err := faviconsDB.Close(); err != nil {
+		t.Fatalf("closing favicons.sqlite: %v", err)
+	}
+
+	if err := cleanupFavicons(profileDir, false); err != nil {
+		t.Fatalf("cleanupFavicons: %v", err)
+	}
+
+	faviconsDB, err = sql.Open("sqlite3", "file:"+filepath.Join(profileDir, "favicons.sqlite"))
+	if err != nil {
+		t.Fatalf("reopening favicons.sqlite: %v", err)
+	}
+	defer faviconsDB.Close()
+
+	var remaining []string
+	rows, err := faviconsDB.Query("SELECT page_url FROM moz_pages_w_icons")
+	if err != nil {
+		t.Fatalf("querying moz_pages_w_icons: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			t.Fatalf("scanning page_url: %v", err)
+		}
+		remaining = append(remaining, url)
+	}
+
+	if len(remaining) != 1 || remaining[0] != "https://still-here.example/" {
+		t.Fatalf("expected only the favicon tied to an existing place to remain, got %v", remaining)
+	}
+}